@@ -0,0 +1,265 @@
+// Package protocol defines the wire format spoken between a client and a
+// room: concrete, json-tagged structs for every command instead of the
+// map[string]interface{} + unchecked type assertions the server used to
+// rely on, which would panic on a malformed payload.
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Command names a message type on the wire.
+type Command string
+
+const (
+	CreateRoomCommand      Command = "CREATE_ROOM"
+	JoinRoomCommand        Command = "JOIN_ROOM"
+	JoinRandomRoomCommand  Command = "JOIN_RANDOM_ROOM"
+	MovementCommand        Command = "MOVEMENT"
+	PlayerLeftCommand      Command = "PLAYER_LEFT"
+	NewMasterCommand       Command = "NEW_MASTER"
+	RoomJoinedCommand      Command = "ROOM_JOINED"
+	RoomClosedCommand      Command = "ROOM_CLOSED"
+	ErrorCommand           Command = "ERROR"
+	ResumeCommand          Command = "RESUME"
+	ResumedCommand         Command = "RESUMED"
+	StartGameCommand       Command = "START_GAME"
+	KickPlayerCommand      Command = "KICK_PLAYER"
+	CloseRoomCommand       Command = "CLOSE_ROOM"
+	PlayerKickedCommand    Command = "PLAYER_KICKED"
+	GameStartedCommand     Command = "GAME_STARTED"
+	RtcOfferCommand        Command = "RTC_OFFER"
+	RtcAnswerCommand       Command = "RTC_ANSWER"
+	RtcIceCandidateCommand Command = "RTC_ICE_CANDIDATE"
+	RtcHangupCommand       Command = "RTC_HANGUP"
+	GetIceServersCommand   Command = "GET_ICE_SERVERS"
+	IceServersCommand      Command = "ICE_SERVERS"
+)
+
+// ErrorCode lets clients branch on what went wrong without parsing prose.
+type ErrorCode string
+
+const (
+	BadRequest    ErrorCode = "bad_request"
+	RoomNotFound  ErrorCode = "room_not_found"
+	NotInRoom     ErrorCode = "not_in_room"
+	AlreadyJoined ErrorCode = "already_joined"
+	RoomFull      ErrorCode = "room_full"
+)
+
+// IceServer mirrors one entry of the RTCConfiguration.iceServers a browser
+// RTCPeerConnection expects, loaded from the -ice-servers JSON config file.
+type IceServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// RoomData mirrors the nested `data: {roomName}` object the client sends
+// alongside CREATE_ROOM/JOIN_ROOM/JOIN_RANDOM_ROOM.
+type RoomData struct {
+	RoomName string `json:"roomName"`
+}
+
+// CreateRoom asks the server to create a new room with the sender as master.
+type CreateRoom struct {
+	PlayerID string   `json:"player_id"`
+	Data     RoomData `json:"data"`
+}
+
+// JoinRoom asks the server to add the sender to an existing room.
+type JoinRoom struct {
+	PlayerID string   `json:"player_id"`
+	Data     RoomData `json:"data"`
+}
+
+// JoinRandomRoom asks the server to place the sender into any joinable room.
+type JoinRandomRoom struct {
+	PlayerID string `json:"player_id"`
+}
+
+// Resume asks the server to rebind a disconnected player's slot to this
+// session, replaying any broadcasts with a seq greater than LastSeq.
+type Resume struct {
+	ResumeToken string `json:"resume_token"`
+	LastSeq     uint64 `json:"last_seq"`
+}
+
+// StartGame asks the server to mark a room as started, e.g. so it stops
+// accepting random matchmaking joins. Only the room's master, or whoever
+// presents AdminToken, may do this.
+type StartGame struct {
+	PlayerID   string `json:"player_id"`
+	AdminToken string `json:"admin_token,omitempty"`
+}
+
+// KickPlayer asks the server to evict a player from the sender's room.
+// Only the room's master, or whoever presents AdminToken, may do this.
+type KickPlayer struct {
+	PlayerID   string `json:"player_id"`
+	TargetID   string `json:"target_id"`
+	Reason     string `json:"reason"`
+	AdminToken string `json:"admin_token,omitempty"`
+}
+
+// CloseRoom asks the server to shut down the sender's room immediately.
+// Only the room's master, or whoever presents AdminToken, may do this.
+type CloseRoom struct {
+	PlayerID   string `json:"player_id"`
+	AdminToken string `json:"admin_token,omitempty"`
+}
+
+// Movement is a high-frequency, opaque gameplay payload that gets
+// broadcast to every other player in the sender's room.
+type Movement struct {
+	PlayerID string          `json:"player_id"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// RtcSignal is a WebRTC signaling message relayed verbatim to ToPlayerID,
+// the addressed peer within the sender's room. It covers RTC_OFFER,
+// RTC_ANSWER, RTC_ICE_CANDIDATE and RTC_HANGUP, which all share this shape;
+// SDP and Candidate are mutually exclusive and the server never inspects
+// either, it only routes on the player IDs.
+type RtcSignal struct {
+	FromPlayerID string          `json:"from_player_id"`
+	ToPlayerID   string          `json:"to_player_id"`
+	SDP          json.RawMessage `json:"sdp,omitempty"`
+	Candidate    json.RawMessage `json:"candidate,omitempty"`
+}
+
+// GetIceServers asks the server for the STUN/TURN servers a client should
+// use to negotiate a WebRTC peer connection.
+type GetIceServers struct {
+	PlayerID string `json:"player_id"`
+}
+
+// IceServersResponse replies to GET_ICE_SERVERS with the server's
+// configured STUN/TURN servers.
+type IceServersResponse struct {
+	Command    Command     `json:"command"`
+	IceServers []IceServer `json:"ice_servers"`
+}
+
+// PlayerLeft is sent to the remaining players in a room when one leaves.
+type PlayerLeft struct {
+	Command  Command `json:"command"`
+	PlayerID string  `json:"player_id"`
+}
+
+// NewMaster is sent to every player in a room when master ownership changes.
+type NewMaster struct {
+	Command Command `json:"command"`
+	Master  string  `json:"master"`
+}
+
+// RoomClosed is sent to whoever is still in a room when it shuts down,
+// e.g. after its timeout expires or the master issues CLOSE_ROOM.
+type RoomClosed struct {
+	Command Command `json:"command"`
+}
+
+// GameStarted is sent to every player in a room once the master (or an
+// admin) issues START_GAME.
+type GameStarted struct {
+	Command Command `json:"command"`
+}
+
+// PlayerKicked is sent directly to a player the master (or an admin)
+// evicted with KICK_PLAYER.
+type PlayerKicked struct {
+	Command Command `json:"command"`
+	Reason  string  `json:"reason"`
+}
+
+// RoomJoined is sent back to a player once they've joined or created a
+// room. The same shape, with Command set to ResumedCommand, acknowledges a
+// successful RESUME.
+type RoomJoined struct {
+	Command     Command `json:"command"`
+	RoomName    string  `json:"roomName"`
+	Master      string  `json:"master"`
+	PlayerCount int     `json:"playerCount"`
+	ResumeToken string  `json:"resume_token"`
+}
+
+// Error is sent back to a single client instead of silently logging and
+// dropping their message server-side.
+type Error struct {
+	Command Command   `json:"command"`
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	Details string    `json:"details,omitempty"`
+}
+
+// Inbound is a parsed client message. Exactly one of the fields is non-nil,
+// matching Command.
+type Inbound struct {
+	Command        Command
+	CreateRoom     *CreateRoom
+	JoinRoom       *JoinRoom
+	JoinRandomRoom *JoinRandomRoom
+	Movement       *Movement
+	Resume         *Resume
+	StartGame      *StartGame
+	KickPlayer     *KickPlayer
+	CloseRoom      *CloseRoom
+	RtcSignal      *RtcSignal
+	GetIceServers  *GetIceServers
+}
+
+// UnmarshalJSON reads the `command` discriminator first, then decodes the
+// rest of the payload into the matching concrete type. An unrecognised or
+// malformed command is reported as an error rather than panicking later on
+// a failed type assertion.
+func (in *Inbound) UnmarshalJSON(b []byte) error {
+	var envelope struct {
+		Command Command `json:"command"`
+	}
+	if err := json.Unmarshal(b, &envelope); err != nil {
+		return fmt.Errorf("protocol: malformed message: %w", err)
+	}
+
+	in.Command = envelope.Command
+	switch envelope.Command {
+	case CreateRoomCommand:
+		in.CreateRoom = &CreateRoom{}
+		return json.Unmarshal(b, in.CreateRoom)
+	case JoinRoomCommand:
+		in.JoinRoom = &JoinRoom{}
+		return json.Unmarshal(b, in.JoinRoom)
+	case JoinRandomRoomCommand:
+		in.JoinRandomRoom = &JoinRandomRoom{}
+		return json.Unmarshal(b, in.JoinRandomRoom)
+	case MovementCommand:
+		in.Movement = &Movement{}
+		return json.Unmarshal(b, in.Movement)
+	case ResumeCommand:
+		in.Resume = &Resume{}
+		return json.Unmarshal(b, in.Resume)
+	case StartGameCommand:
+		in.StartGame = &StartGame{}
+		return json.Unmarshal(b, in.StartGame)
+	case KickPlayerCommand:
+		in.KickPlayer = &KickPlayer{}
+		return json.Unmarshal(b, in.KickPlayer)
+	case CloseRoomCommand:
+		in.CloseRoom = &CloseRoom{}
+		return json.Unmarshal(b, in.CloseRoom)
+	case RtcOfferCommand, RtcAnswerCommand, RtcIceCandidateCommand, RtcHangupCommand:
+		in.RtcSignal = &RtcSignal{}
+		return json.Unmarshal(b, in.RtcSignal)
+	case GetIceServersCommand:
+		in.GetIceServers = &GetIceServers{}
+		return json.Unmarshal(b, in.GetIceServers)
+	default:
+		return fmt.Errorf("protocol: unknown command %q", envelope.Command)
+	}
+}
+
+// Marshal is a small convenience wrapper so callers don't have to import
+// encoding/json just to serialise an outbound message.
+func Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
@@ -0,0 +1,812 @@
+// Package room is the transport-agnostic game engine: rooms, players and
+// the commands that mutate them. It knows nothing about websockets or
+// melody; anything that can accept bytes can be a Sink, which makes the
+// engine usable from a real server, an in-process test harness, or an
+// alternate transport entirely.
+package room
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/j-c-levin/mermaid-symphony-tutorial/Server/protocol"
+)
+
+// DefaultTimeout is how long a room lives if nothing closes it sooner.
+const DefaultTimeout = 4 * time.Hour
+
+// DefaultHistorySize is how many broadcast messages a room replays to a
+// resuming player by default.
+const DefaultHistorySize = 32
+
+// DefaultResumeGrace is how long a disconnected player's slot is held open
+// for a RESUME by default.
+const DefaultResumeGrace = 30 * time.Second
+
+// commandBufferSize is how many pending commands a room's actor goroutine
+// will queue before a caller sending to it blocks.
+const commandBufferSize = 32
+
+// Sink is anything that can have a message delivered to it. Package wsserver
+// wraps *melody.Session in a small adapter to satisfy it; tests and alternate
+// transports can supply their own.
+type Sink interface {
+	Send([]byte) error
+}
+
+// Player is one participant in a room.
+type Player struct {
+	ID          string
+	Sink        Sink
+	ResumeToken string
+}
+
+// ResumeTracker lets a Room publish which resume tokens are currently
+// redeemable for it, so a transport can route a RESUME command to the
+// right room without the client having to name it.
+type ResumeTracker interface {
+	Track(token, roomID string)
+	Untrack(token string)
+}
+
+type commandKind int
+
+const (
+	cmdJoin commandKind = iota
+	cmdLeave
+	cmdBroadcast
+	cmdBroadcastOthers
+	cmdPromoteMaster
+	cmdResume
+	cmdExpireResume
+	cmdStartGame
+	cmdKickPlayer
+	cmdCloseRoom
+	cmdRelay
+)
+
+type command struct {
+	kind     commandKind
+	player   Player
+	from     Sink
+	msg      []byte
+	token    string
+	lastSeq  uint64
+	isAdmin  bool
+	targetID string
+	reason   string
+	result   chan bool
+}
+
+// pendingPlayer is a disconnected player whose slot is held open in case
+// they reconnect with a matching resume token before the grace timer fires.
+type pendingPlayer struct {
+	player Player
+	timer  *time.Timer
+}
+
+// historyEntry is one previously broadcast message, kept so a resuming
+// player can be replayed everything they missed.
+type historyEntry struct {
+	seq uint64
+	msg []byte
+}
+
+// Room owns one game session: its players, its master, and its lifecycle.
+// All state is private to the actor goroutine started by New; callers only
+// ever interact with it through the exported methods, which enqueue
+// commands rather than touching fields directly.
+type Room struct {
+	ID          string
+	master      string
+	players     []Player
+	started     bool
+	capacity    int
+	pending     map[string]pendingPlayer
+	seq         uint64
+	history     []historyEntry
+	historySize int
+	resumeGrace time.Duration
+	commands    chan command
+	cancel      context.CancelFunc
+	onClose     func(id string)
+	resume      ResumeTracker
+
+	statsMu     sync.Mutex
+	cachedStats Stats
+}
+
+// Stats is a point-in-time, thread-safe snapshot of a room, used by
+// matchmaking to decide whether a room is joinable without going through
+// its actor goroutine.
+type Stats struct {
+	PlayerCount int
+	Started     bool
+	Capacity    int
+}
+
+// Config holds a room's tunable, per-deployment behaviour.
+type Config struct {
+	// Timeout bounds how long a room can live, even if nothing else closes it.
+	Timeout time.Duration
+	// HistorySize is how many broadcast messages are kept for resume replay.
+	HistorySize int
+	// ResumeGrace is how long a disconnected player's slot is held open.
+	ResumeGrace time.Duration
+	// Capacity is the maximum number of players the room accepts. 0 means
+	// unlimited.
+	Capacity int
+	// OnClose, if non-nil, is called from the actor goroutine once the room
+	// has shut down, e.g. so a supervisor can remove it from a Registry.
+	OnClose func(id string)
+	// Resume, if non-nil, is told when one of this room's resume tokens
+	// becomes redeemable (its player disconnects) and when it stops being so
+	// (resumed, expired, or the room shut down), so a transport can route
+	// RESUME commands to it.
+	Resume ResumeTracker
+}
+
+// New starts a room's actor goroutine and returns a handle to it. parent is
+// the server-lifetime context; the room gets its own timeout plus a cancel
+// that fires once the last player leaves and all pending resumes expire.
+func New(id string, master Player, parent context.Context, cfg Config) *Room {
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	historySize := cfg.HistorySize
+	if historySize == 0 {
+		historySize = DefaultHistorySize
+	}
+	resumeGrace := cfg.ResumeGrace
+	if resumeGrace == 0 {
+		resumeGrace = DefaultResumeGrace
+	}
+
+	timeoutCtx, cancelTimeout := context.WithTimeout(parent, timeout)
+	doneCtx, cancelDone := context.WithCancel(timeoutCtx)
+
+	master.ResumeToken = newResumeToken()
+
+	r := &Room{
+		ID:          id,
+		master:      master.ID,
+		players:     []Player{master},
+		capacity:    cfg.Capacity,
+		pending:     make(map[string]pendingPlayer),
+		historySize: historySize,
+		resumeGrace: resumeGrace,
+		commands:    make(chan command, commandBufferSize),
+		cancel: func() {
+			cancelDone()
+			cancelTimeout()
+		},
+		onClose: cfg.OnClose,
+		resume:  cfg.Resume,
+	}
+	r.refreshStats()
+
+	go r.run(doneCtx)
+
+	return r
+}
+
+// Stats returns a thread-safe snapshot of the room, safe to call from any
+// goroutine without going through the command channel.
+func (r *Room) Stats() Stats {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	return r.cachedStats
+}
+
+// refreshStats updates the cached snapshot read by Stats. Called once by New
+// before the actor goroutine starts, and afterwards only from the actor
+// goroutine, after a mutation to players or started.
+func (r *Room) refreshStats() {
+	stats := Stats{PlayerCount: len(r.players), Started: r.started, Capacity: r.capacity}
+	r.statsMu.Lock()
+	r.cachedStats = stats
+	r.statsMu.Unlock()
+}
+
+func newResumeToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		fmt.Printf("room: generate resume token: %s \n", err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Join adds a player to the room and replies to them with ROOM_JOINED. It
+// blocks until the room has resolved the request and reports whether p was
+// actually admitted, so a caller can avoid treating a rejected (e.g.
+// room_full) sink as a room member.
+func (r *Room) Join(p Player) bool {
+	result := make(chan bool, 1)
+	r.commands <- command{kind: cmdJoin, player: p, result: result}
+	return <-result
+}
+
+// Leave marks the session's player as disconnected. Their slot is held open
+// for the room's resume grace period in case they RESUME with a matching
+// token; PLAYER_LEFT/NEW_MASTER are only broadcast once that grace period
+// expires without a resume.
+func (r *Room) Leave(s Sink) {
+	r.commands <- command{kind: cmdLeave, from: s}
+}
+
+// Resume rebinds a disconnected player's slot to a new session, replaying
+// any broadcasts they missed since lastSeq. It blocks until the room has
+// resolved the request and reports false, without rebinding anything, if
+// token doesn't match a player currently within its resume grace period.
+func (r *Room) Resume(token string, lastSeq uint64, s Sink) bool {
+	result := make(chan bool, 1)
+	r.commands <- command{kind: cmdResume, token: token, lastSeq: lastSeq, from: s, result: result}
+	return <-result
+}
+
+// Broadcast sends msg to every player in the room.
+func (r *Room) Broadcast(msg []byte) {
+	r.commands <- command{kind: cmdBroadcast, msg: msg}
+}
+
+// BroadcastOthers sends msg to every player in the room except from.
+func (r *Room) BroadcastOthers(from Sink, msg []byte) {
+	r.commands <- command{kind: cmdBroadcastOthers, from: from, msg: msg}
+}
+
+// PromoteMaster makes playerID the room's master and announces it.
+func (r *Room) PromoteMaster(playerID string) {
+	r.commands <- command{kind: cmdPromoteMaster, player: Player{ID: playerID}}
+}
+
+// StartGame marks the room as started, so it stops accepting random
+// matchmaking joins. from must be the session bound to the room's master
+// unless isAdmin is set; the caller cannot claim master identity by simply
+// naming it, since authorization is derived from from, not any client-
+// supplied player ID.
+func (r *Room) StartGame(from Sink, isAdmin bool) {
+	r.commands <- command{kind: cmdStartGame, from: from, isAdmin: isAdmin}
+}
+
+// KickPlayer evicts targetID from the room. from must be the session bound
+// to the room's master unless isAdmin is set.
+func (r *Room) KickPlayer(from Sink, isAdmin bool, targetID, reason string) {
+	r.commands <- command{kind: cmdKickPlayer, from: from, isAdmin: isAdmin, targetID: targetID, reason: reason}
+}
+
+// CloseRoom shuts the room down immediately. from must be the session bound
+// to the room's master unless isAdmin is set.
+func (r *Room) CloseRoom(from Sink, isAdmin bool) {
+	r.commands <- command{kind: cmdCloseRoom, from: from, isAdmin: isAdmin}
+}
+
+// Relay forwards msg, unmodified, to the player with targetID, e.g. a
+// WebRTC signaling message addressed to a specific peer. The server never
+// inspects msg's contents; it only routes on targetID. from is replied to
+// with an error if targetID isn't currently in the room.
+func (r *Room) Relay(from Sink, targetID string, msg []byte) {
+	r.commands <- command{kind: cmdRelay, from: from, targetID: targetID, msg: msg}
+}
+
+// run is the room's actor loop; it is the only goroutine that ever reads or
+// writes r's private fields, so no locking is needed inside it.
+func (r *Room) run(ctx context.Context) {
+	r.respondRoomJoined(r.players[0].Sink, r.players[0].ResumeToken)
+
+	for {
+		select {
+		case cmd := <-r.commands:
+			r.handle(cmd)
+		case <-ctx.Done():
+			r.closeAll()
+			r.stopPendingTimers()
+			if r.onClose != nil {
+				r.onClose(r.ID)
+			}
+			return
+		}
+	}
+}
+
+func (r *Room) handle(cmd command) {
+	switch cmd.kind {
+	case cmdJoin:
+		r.join(cmd.player, cmd.result)
+	case cmdLeave:
+		r.leave(cmd.from)
+	case cmdBroadcast:
+		r.broadcast(cmd.msg, r.sinks())
+	case cmdBroadcastOthers:
+		r.broadcast(cmd.msg, filter(r.sinks(), func(s Sink) bool { return s != cmd.from }))
+	case cmdPromoteMaster:
+		r.master = cmd.player.ID
+		r.announceNewMaster()
+	case cmdResume:
+		r.doResume(cmd.token, cmd.lastSeq, cmd.from, cmd.result)
+	case cmdExpireResume:
+		r.expireResume(cmd.token)
+	case cmdStartGame:
+		r.handleStartGame(cmd.from, cmd.isAdmin)
+	case cmdKickPlayer:
+		r.handleKickPlayer(cmd.from, cmd.isAdmin, cmd.targetID, cmd.reason)
+	case cmdCloseRoom:
+		r.handleCloseRoom(cmd.from, cmd.isAdmin)
+	case cmdRelay:
+		r.relay(cmd.from, cmd.targetID, cmd.msg)
+	}
+}
+
+// relay sends msg to targetID alone, without touching seq or history: these
+// are peer-addressed signaling messages, not room broadcasts.
+func (r *Room) relay(from Sink, targetID string, msg []byte) {
+	i := indexByID(r.players, targetID)
+	if i == -1 {
+		r.respondError(from, protocol.BadRequest, fmt.Sprintf("player %q is not in this room", targetID))
+		return
+	}
+	r.send(msg, []Sink{r.players[i].Sink})
+}
+
+// authorized reports whether from may perform a master-only action: either
+// isAdmin was set by the transport after validating an admin token, or from
+// is the sink of whichever player actually joined as this room's master.
+// Identity is derived from the session binding the transport already
+// tracks, never from a client-supplied player ID, which anyone could forge.
+func (r *Room) authorized(from Sink, isAdmin bool) bool {
+	if isAdmin {
+		return true
+	}
+	i := indexBySink(r.players, from)
+	return i != -1 && r.players[i].ID == r.master
+}
+
+func (r *Room) respondError(s Sink, code protocol.ErrorCode, msg string) {
+	b, err := protocol.Marshal(protocol.Error{Command: protocol.ErrorCommand, Code: code, Message: msg})
+	if err != nil {
+		fmt.Printf("room: marshal error response: %s \n", err.Error())
+		return
+	}
+	r.send(b, []Sink{s})
+}
+
+func (r *Room) handleStartGame(from Sink, isAdmin bool) {
+	if !r.authorized(from, isAdmin) {
+		r.respondError(from, protocol.BadRequest, "only the room's master or an admin can start the game")
+		return
+	}
+
+	r.started = true
+	r.refreshStats()
+
+	msg, err := protocol.Marshal(protocol.GameStarted{Command: protocol.GameStartedCommand})
+	if err != nil {
+		fmt.Printf("room: marshal game started: %s \n", err.Error())
+		return
+	}
+	r.broadcast(msg, r.sinks())
+}
+
+func (r *Room) handleKickPlayer(from Sink, isAdmin bool, targetID, reason string) {
+	if !r.authorized(from, isAdmin) {
+		r.respondError(from, protocol.BadRequest, "only the room's master or an admin can kick a player")
+		return
+	}
+
+	i := indexByID(r.players, targetID)
+	if i == -1 {
+		r.respondError(from, protocol.BadRequest, fmt.Sprintf("player %q is not in this room", targetID))
+		return
+	}
+
+	kicked := r.players[i]
+	r.players = append(r.players[:i], r.players[i+1:]...)
+	r.refreshStats()
+
+	msg, err := protocol.Marshal(protocol.PlayerKicked{Command: protocol.PlayerKickedCommand, Reason: reason})
+	if err != nil {
+		fmt.Printf("room: marshal player kicked: %s \n", err.Error())
+		return
+	}
+	r.send(msg, []Sink{kicked.Sink})
+
+	if len(r.players) == 0 {
+		r.cancel()
+		return
+	}
+
+	if r.master == kicked.ID {
+		r.master = r.players[0].ID
+		r.announceNewMaster()
+	}
+
+	left, err := protocol.Marshal(protocol.PlayerLeft{Command: protocol.PlayerLeftCommand, PlayerID: kicked.ID})
+	if err != nil {
+		fmt.Printf("room: marshal player left: %s \n", err.Error())
+		return
+	}
+	r.broadcast(left, r.sinks())
+}
+
+func (r *Room) handleCloseRoom(from Sink, isAdmin bool) {
+	if !r.authorized(from, isAdmin) {
+		r.respondError(from, protocol.BadRequest, "only the room's master or an admin can close the room")
+		return
+	}
+	r.cancel()
+}
+
+func (r *Room) join(p Player, result chan bool) {
+	// Idempotent rejoin: a player already in the room (e.g. a retried
+	// JOIN_ROOM) gets the same ROOM_JOINED reply instead of being added
+	// a second time.
+	if i := indexByID(r.players, p.ID); i != -1 {
+		r.respondRoomJoined(p.Sink, r.players[i].ResumeToken)
+		result <- true
+		return
+	}
+
+	if r.capacity > 0 && len(r.players) >= r.capacity {
+		r.respondError(p.Sink, protocol.RoomFull, fmt.Sprintf("room %q is full", r.ID))
+		result <- false
+		return
+	}
+
+	p.ResumeToken = newResumeToken()
+	r.players = append(r.players, p)
+	r.refreshStats()
+	r.respondRoomJoined(p.Sink, p.ResumeToken)
+	result <- true
+}
+
+// leave holds the session's slot open for resumeGrace instead of
+// immediately tearing it down, so a flaky connection can RESUME without
+// the rest of the room seeing a PLAYER_LEFT/NEW_MASTER churn.
+func (r *Room) leave(s Sink) {
+	i := indexBySink(r.players, s)
+	if i == -1 {
+		return
+	}
+
+	leaving := r.players[i]
+	r.players = append(r.players[:i], r.players[i+1:]...)
+	r.refreshStats()
+
+	token := leaving.ResumeToken
+	if r.resume != nil {
+		r.resume.Track(token, r.ID)
+	}
+	r.pending[token] = pendingPlayer{
+		player: leaving,
+		timer: time.AfterFunc(r.resumeGrace, func() {
+			r.commands <- command{kind: cmdExpireResume, token: token}
+		}),
+	}
+}
+
+// expireResume finalises a disconnect that was never resumed: promotes a
+// new master if needed and announces the player's departure.
+func (r *Room) expireResume(token string) {
+	pending, ok := r.pending[token]
+	if !ok {
+		return
+	}
+	delete(r.pending, token)
+	if r.resume != nil {
+		r.resume.Untrack(token)
+	}
+
+	leaving := pending.player
+
+	if len(r.players) == 0 && len(r.pending) == 0 {
+		r.cancel()
+		return
+	}
+
+	if r.master == leaving.ID && len(r.players) > 0 {
+		r.master = r.players[0].ID
+		r.announceNewMaster()
+	}
+
+	msg, err := protocol.Marshal(protocol.PlayerLeft{
+		Command:  protocol.PlayerLeftCommand,
+		PlayerID: leaving.ID,
+	})
+	if err != nil {
+		fmt.Printf("room: marshal player left: %s \n", err.Error())
+		return
+	}
+	r.broadcast(msg, r.sinks())
+}
+
+// doResume rebinds a disconnected player's slot to a new sink and replays
+// everything broadcast since lastSeq.
+func (r *Room) doResume(token string, lastSeq uint64, s Sink, result chan bool) {
+	pending, ok := r.pending[token]
+	if !ok {
+		result <- false
+		return
+	}
+	pending.timer.Stop()
+	delete(r.pending, token)
+	if r.resume != nil {
+		r.resume.Untrack(token)
+	}
+
+	resumed := pending.player
+	resumed.Sink = s
+	r.players = append(r.players, resumed)
+	r.refreshStats()
+	result <- true
+
+	msg, err := protocol.Marshal(protocol.RoomJoined{
+		Command:     protocol.ResumedCommand,
+		RoomName:    r.ID,
+		Master:      r.master,
+		PlayerCount: len(r.players),
+		ResumeToken: token,
+	})
+	if err != nil {
+		fmt.Printf("room: marshal resumed: %s \n", err.Error())
+		return
+	}
+	r.send(msg, []Sink{s})
+
+	for _, h := range r.history {
+		if h.seq > lastSeq {
+			r.send(h.msg, []Sink{s})
+		}
+	}
+}
+
+// closeAll notifies whoever is still in the room that it is shutting down.
+// Called from the actor goroutine as it exits.
+func (r *Room) closeAll() {
+	if len(r.players) == 0 {
+		return
+	}
+	msg, err := protocol.Marshal(protocol.RoomClosed{Command: protocol.RoomClosedCommand})
+	if err != nil {
+		fmt.Printf("room: marshal room closed: %s \n", err.Error())
+		return
+	}
+	r.broadcast(msg, r.sinks())
+}
+
+// stopPendingTimers cancels every pending disconnect's resume-grace timer.
+// Called from the actor goroutine as it exits, so a timer that would
+// otherwise fire after the room has already shut down doesn't send a
+// cmdExpireResume nobody will ever process.
+func (r *Room) stopPendingTimers() {
+	for token, p := range r.pending {
+		p.timer.Stop()
+		if r.resume != nil {
+			r.resume.Untrack(token)
+		}
+	}
+	r.pending = make(map[string]pendingPlayer)
+}
+
+func (r *Room) announceNewMaster() {
+	msg, err := protocol.Marshal(protocol.NewMaster{
+		Command: protocol.NewMasterCommand,
+		Master:  r.master,
+	})
+	if err != nil {
+		fmt.Printf("room: marshal new master: %s \n", err.Error())
+		return
+	}
+	r.broadcast(msg, r.sinks())
+}
+
+func (r *Room) respondRoomJoined(s Sink, token string) {
+	msg, err := protocol.Marshal(protocol.RoomJoined{
+		Command:     protocol.RoomJoinedCommand,
+		RoomName:    r.ID,
+		Master:      r.master,
+		PlayerCount: len(r.players),
+		ResumeToken: token,
+	})
+	if err != nil {
+		fmt.Printf("room: marshal room joined: %s \n", err.Error())
+		return
+	}
+	r.send(msg, []Sink{s})
+}
+
+// broadcast assigns the next sequence number to msg, keeps it in the
+// room's replay history, and delivers it to every sink in to.
+func (r *Room) broadcast(msg []byte, to []Sink) {
+	r.seq++
+	msg = withSeq(msg, r.seq)
+
+	r.history = append(r.history, historyEntry{seq: r.seq, msg: msg})
+	if len(r.history) > r.historySize {
+		r.history = r.history[len(r.history)-r.historySize:]
+	}
+
+	r.send(msg, to)
+}
+
+// withSeq stamps a monotonically increasing "seq" field onto msg so a
+// resuming client can ask for exactly what it missed. Malformed messages
+// are sent unchanged.
+func withSeq(msg []byte, seq uint64) []byte {
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(msg, &decoded); err != nil {
+		return msg
+	}
+	decoded["seq"] = seq
+
+	stamped, err := json.Marshal(decoded)
+	if err != nil {
+		return msg
+	}
+	return stamped
+}
+
+func (r *Room) send(msg []byte, to []Sink) {
+	for _, s := range to {
+		if err := s.Send(msg); err != nil {
+			fmt.Printf("room: send error: %s \n", err.Error())
+		}
+	}
+}
+
+func (r *Room) sinks() []Sink {
+	out := make([]Sink, 0, len(r.players))
+	for _, p := range r.players {
+		out = append(out, p.Sink)
+	}
+	return out
+}
+
+func filter(vs []Sink, f func(Sink) bool) []Sink {
+	out := make([]Sink, 0, len(vs))
+	for _, v := range vs {
+		if f(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func indexBySink(players []Player, s Sink) int {
+	for i, p := range players {
+		if p.Sink == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func indexByID(players []Player, id string) int {
+	for i, p := range players {
+		if p.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Registry is a mutex-protected lookup of rooms by ID, race-free to add to,
+// remove from, or list concurrently from multiple transport goroutines.
+type Registry struct {
+	mu    sync.Mutex
+	rooms map[string]*Room
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{rooms: make(map[string]*Room)}
+}
+
+// Get returns the room with the given ID, if any.
+func (reg *Registry) Get(id string) (*Room, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	r, ok := reg.rooms[id]
+	return r, ok
+}
+
+// Set registers a room under its ID.
+func (reg *Registry) Set(r *Room) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.rooms[r.ID] = r
+}
+
+// Remove drops a room from the registry, e.g. once it has closed.
+func (reg *Registry) Remove(id string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	delete(reg.rooms, id)
+}
+
+// IDs returns every room ID currently registered, sorted for deterministic
+// iteration, e.g. by matchmaking scanning for a joinable room.
+func (reg *Registry) IDs() []string {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	ids := make([]string, 0, len(reg.rooms))
+	for id := range reg.rooms {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Index is a mutex-protected lookup of which room a given sink currently
+// belongs to, race-free to update from multiple transport goroutines.
+type Index struct {
+	mu    sync.Mutex
+	rooms map[Sink]string
+}
+
+// NewIndex returns an empty Index.
+func NewIndex() *Index {
+	return &Index{rooms: make(map[Sink]string)}
+}
+
+// Set records that s belongs to the room with the given ID.
+func (idx *Index) Set(s Sink, roomID string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.rooms[s] = roomID
+}
+
+// Get returns the room ID s currently belongs to, if any.
+func (idx *Index) Get(s Sink) (string, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	roomID, ok := idx.rooms[s]
+	return roomID, ok
+}
+
+// Delete removes s from the index, e.g. once it has disconnected.
+func (idx *Index) Delete(s Sink) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	delete(idx.rooms, s)
+}
+
+// TokenIndex is a mutex-protected lookup of which room a resume token was
+// issued by, so a transport can route a RESUME command without the client
+// having to name its room. It implements ResumeTracker.
+type TokenIndex struct {
+	mu    sync.Mutex
+	rooms map[string]string
+}
+
+// NewTokenIndex returns an empty TokenIndex.
+func NewTokenIndex() *TokenIndex {
+	return &TokenIndex{rooms: make(map[string]string)}
+}
+
+// Track records that token is currently redeemable against roomID.
+func (idx *TokenIndex) Track(token, roomID string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.rooms[token] = roomID
+}
+
+// Untrack removes token, e.g. once it has been redeemed or expired.
+func (idx *TokenIndex) Untrack(token string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	delete(idx.rooms, token)
+}
+
+// Get returns the room ID token was issued by, if it is still redeemable.
+func (idx *TokenIndex) Get(token string) (string, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	roomID, ok := idx.rooms[token]
+	return roomID, ok
+}
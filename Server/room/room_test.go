@@ -0,0 +1,178 @@
+package room
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/j-c-levin/mermaid-symphony-tutorial/Server/protocol"
+)
+
+// fakeSink is an in-process Sink used to observe what a Room sends without
+// standing up a websocket, which is the whole point of the Sink interface.
+type fakeSink struct {
+	msgs chan []byte
+}
+
+func newFakeSink() *fakeSink {
+	return &fakeSink{msgs: make(chan []byte, 16)}
+}
+
+func (f *fakeSink) Send(b []byte) error {
+	f.msgs <- b
+	return nil
+}
+
+// recv waits for the next message sent to f and decodes it as JSON, failing
+// the test if none arrives in time.
+func (f *fakeSink) recv(t *testing.T) map[string]interface{} {
+	t.Helper()
+	select {
+	case b := <-f.msgs:
+		var m map[string]interface{}
+		if err := json.Unmarshal(b, &m); err != nil {
+			t.Fatalf("unmarshal message: %v", err)
+		}
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message")
+		return nil
+	}
+}
+
+func TestJoinIdempotentRejoin(t *testing.T) {
+	masterSink := newFakeSink()
+	r := New("room1", Player{ID: "m1", Sink: masterSink}, context.Background(), Config{})
+
+	first := masterSink.recv(t)
+	token, _ := first["resume_token"].(string)
+	if token == "" {
+		t.Fatalf("expected a resume token in the initial ROOM_JOINED, got %v", first)
+	}
+
+	// Rejoining with the same player ID must not add a second player or
+	// issue a new token; it should just replay the same ROOM_JOINED reply.
+	r.Join(Player{ID: "m1", Sink: masterSink})
+	second := masterSink.recv(t)
+	if second["command"] != string(protocol.RoomJoinedCommand) {
+		t.Fatalf("expected ROOM_JOINED, got %v", second["command"])
+	}
+	if second["resume_token"] != token {
+		t.Fatalf("rejoin got a different resume token: %v vs %v", second["resume_token"], token)
+	}
+	if got := r.Stats().PlayerCount; got != 1 {
+		t.Fatalf("expected 1 player after idempotent rejoin, got %d", got)
+	}
+}
+
+func TestJoinRejectsOverCapacityWithRoomFull(t *testing.T) {
+	masterSink := newFakeSink()
+	r := New("cap1", Player{ID: "m1", Sink: masterSink}, context.Background(), Config{Capacity: 1})
+	masterSink.recv(t) // initial ROOM_JOINED
+
+	second := newFakeSink()
+	r.Join(Player{ID: "p2", Sink: second})
+
+	msg := second.recv(t)
+	if msg["command"] != string(protocol.ErrorCommand) {
+		t.Fatalf("expected ERROR, got %v", msg["command"])
+	}
+	if msg["code"] != string(protocol.RoomFull) {
+		t.Fatalf("expected code %q, got %v", protocol.RoomFull, msg["code"])
+	}
+	if got := r.Stats().PlayerCount; got != 1 {
+		t.Fatalf("expected room to still have 1 player, got %d", got)
+	}
+}
+
+func TestResumeReplaysMissedHistory(t *testing.T) {
+	masterSink := newFakeSink()
+	tokens := NewTokenIndex()
+	r := New("resume1", Player{ID: "m1", Sink: masterSink}, context.Background(), Config{Resume: tokens})
+	masterSink.recv(t) // initial ROOM_JOINED
+
+	p2Sink := newFakeSink()
+	r.Join(Player{ID: "p2", Sink: p2Sink})
+	joined := p2Sink.recv(t)
+	resumeToken, _ := joined["resume_token"].(string)
+	if resumeToken == "" {
+		t.Fatalf("expected a resume token, got %v", joined)
+	}
+
+	r.Leave(p2Sink)
+	r.Broadcast([]byte(`{"command":"MOVEMENT"}`))
+	masterSink.recv(t) // the broadcast above, now carrying seq 1
+
+	reconnected := newFakeSink()
+	r.Resume(resumeToken, 0, reconnected)
+
+	resumed := reconnected.recv(t)
+	if resumed["command"] != string(protocol.ResumedCommand) {
+		t.Fatalf("expected RESUMED, got %v", resumed["command"])
+	}
+
+	replayed := reconnected.recv(t)
+	if replayed["command"] != "MOVEMENT" {
+		t.Fatalf("expected the missed broadcast to be replayed, got %v", replayed)
+	}
+	if seq, _ := replayed["seq"].(float64); seq != 1 {
+		t.Fatalf("expected replayed message to carry seq 1, got %v", replayed["seq"])
+	}
+}
+
+func TestCloseRoomRejectsNonMasterImpersonation(t *testing.T) {
+	masterSink := newFakeSink()
+	r := New("authz1", Player{ID: "m1", Sink: masterSink}, context.Background(), Config{})
+	masterSink.recv(t) // initial ROOM_JOINED
+
+	attackerSink := newFakeSink()
+	r.Join(Player{ID: "attacker", Sink: attackerSink})
+	attackerSink.recv(t) // ROOM_JOINED for the attacker
+
+	// Authorization must be derived from the attacker's own sink, not from
+	// a player_id they claim in the request, so this must be denied even
+	// though nothing stops a client from naming the master's ID.
+	r.CloseRoom(attackerSink, false)
+	denied := attackerSink.recv(t)
+	if denied["command"] != string(protocol.ErrorCommand) {
+		t.Fatalf("expected the attacker's CLOSE_ROOM to be denied, got %v", denied)
+	}
+
+	// The real master's sink must still be able to close the room.
+	r.CloseRoom(masterSink, false)
+	masterClosed := masterSink.recv(t)
+	if masterClosed["command"] != string(protocol.RoomClosedCommand) {
+		t.Fatalf("expected ROOM_CLOSED, got %v", masterClosed)
+	}
+	attackerClosed := attackerSink.recv(t)
+	if attackerClosed["command"] != string(protocol.RoomClosedCommand) {
+		t.Fatalf("expected ROOM_CLOSED, got %v", attackerClosed)
+	}
+}
+
+func TestKickPlayerAdminBypass(t *testing.T) {
+	masterSink := newFakeSink()
+	r := New("admin1", Player{ID: "m1", Sink: masterSink}, context.Background(), Config{})
+	masterSink.recv(t) // initial ROOM_JOINED
+
+	targetSink := newFakeSink()
+	r.Join(Player{ID: "p2", Sink: targetSink})
+	targetSink.recv(t) // ROOM_JOINED for p2
+
+	// An admin-authorized caller need not even be a room member.
+	outsiderSink := newFakeSink()
+	r.KickPlayer(outsiderSink, true, "p2", "testing admin bypass")
+
+	kicked := targetSink.recv(t)
+	if kicked["command"] != string(protocol.PlayerKickedCommand) {
+		t.Fatalf("expected PLAYER_KICKED, got %v", kicked)
+	}
+	left := masterSink.recv(t)
+	if left["command"] != string(protocol.PlayerLeftCommand) {
+		t.Fatalf("expected PLAYER_LEFT broadcast, got %v", left)
+	}
+	if got := r.Stats().PlayerCount; got != 1 {
+		t.Fatalf("expected 1 player after kick, got %d", got)
+	}
+}
@@ -0,0 +1,353 @@
+// Package wsserver adapts the transport-agnostic room engine to melody
+// websocket sessions: it turns *melody.Session into a room.Sink, decodes
+// incoming frames with protocol.Inbound, and dispatches them to the right
+// room.
+package wsserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gopkg.in/olahol/melody.v1"
+
+	"github.com/j-c-levin/mermaid-symphony-tutorial/Server/protocol"
+	"github.com/j-c-levin/mermaid-symphony-tutorial/Server/room"
+)
+
+// Config holds the per-deployment room behaviour a Server hands to every
+// room it creates.
+type Config struct {
+	// RoomTimeout bounds how long a room can live, even if nothing else closes it.
+	RoomTimeout time.Duration
+	// HistorySize is how many broadcast messages are kept for resume replay.
+	HistorySize int
+	// ResumeGrace is how long a disconnected player's slot is held open.
+	ResumeGrace time.Duration
+	// PerRoom is the maximum number of players a room accepts. 0 means unlimited.
+	PerRoom int
+	// MinPlayers makes random matchmaking prefer joinable rooms with fewer
+	// than this many players over other joinable rooms. A new room is only
+	// opened when no room is joinable at all.
+	MinPlayers int
+	// AdminToken, if non-empty, lets a client bypass the master-only check on
+	// START_GAME/KICK_PLAYER/CLOSE_ROOM by presenting it as admin_token.
+	AdminToken string
+	// IceServers is handed back verbatim in response to GET_ICE_SERVERS, so
+	// clients can negotiate a WebRTC peer connection for MOVEMENT traffic.
+	IceServers []protocol.IceServer
+}
+
+// Server wires melody websocket sessions to the room engine.
+type Server struct {
+	melody *melody.Melody
+	rooms  *room.Registry
+	index  *room.Index
+	tokens *room.TokenIndex
+	cfg    Config
+}
+
+// New builds a Server and registers its melody handlers.
+func New(cfg Config) *Server {
+	s := &Server{
+		melody: melody.New(),
+		rooms:  room.NewRegistry(),
+		index:  room.NewIndex(),
+		tokens: room.NewTokenIndex(),
+		cfg:    cfg,
+	}
+
+	s.melody.HandleDisconnect(s.handleDisconnect)
+	s.melody.HandleMessage(s.handleMessage)
+
+	return s
+}
+
+// HandleRequest upgrades an HTTP request to a websocket connection.
+func (s *Server) HandleRequest(w http.ResponseWriter, r *http.Request) error {
+	return s.melody.HandleRequest(w, r)
+}
+
+// sink adapts a *melody.Session to room.Sink.
+type sink struct {
+	session *melody.Session
+}
+
+func (sk sink) Send(msg []byte) error {
+	return sk.session.Write(msg)
+}
+
+func (s *Server) handleDisconnect(session *melody.Session) {
+	roomID, ok := s.index.Get(sink{session})
+	if !ok {
+		return
+	}
+	s.index.Delete(sink{session})
+
+	rm, ok := s.rooms.Get(roomID)
+	if !ok {
+		return
+	}
+	rm.Leave(sink{session})
+}
+
+func (s *Server) handleMessage(session *melody.Session, b []byte) {
+	var in protocol.Inbound
+	if err := in.UnmarshalJSON(b); err != nil {
+		s.sendError(session, protocol.BadRequest, "malformed message", err.Error())
+		return
+	}
+
+	switch in.Command {
+	case protocol.CreateRoomCommand:
+		s.createRoom(session, in.CreateRoom)
+	case protocol.JoinRoomCommand:
+		s.joinRoom(session, in.JoinRoom.PlayerID, in.JoinRoom.Data.RoomName)
+	case protocol.JoinRandomRoomCommand:
+		s.joinRandomRoom(session, in.JoinRandomRoom.PlayerID)
+	case protocol.MovementCommand:
+		s.broadcastOthers(session, b)
+	case protocol.ResumeCommand:
+		s.resume(session, in.Resume)
+	case protocol.StartGameCommand:
+		s.startGame(session, in.StartGame)
+	case protocol.KickPlayerCommand:
+		s.kickPlayer(session, in.KickPlayer)
+	case protocol.CloseRoomCommand:
+		s.closeRoom(session, in.CloseRoom)
+	case protocol.RtcOfferCommand, protocol.RtcAnswerCommand, protocol.RtcIceCandidateCommand, protocol.RtcHangupCommand:
+		s.relayRtc(session, in.RtcSignal, b)
+	case protocol.GetIceServersCommand:
+		s.sendIceServers(session)
+	default:
+		s.broadcast(session, b)
+	}
+}
+
+func (s *Server) resume(session *melody.Session, in *protocol.Resume) {
+	roomID, ok := s.tokens.Get(in.ResumeToken)
+	if !ok {
+		s.sendError(session, protocol.NotInRoom, "resume token not found or expired", "")
+		return
+	}
+	rm, ok := s.rooms.Get(roomID)
+	if !ok {
+		return
+	}
+
+	if !rm.Resume(in.ResumeToken, in.LastSeq, sink{session}) {
+		s.sendError(session, protocol.NotInRoom, "resume token not found or expired", "")
+		return
+	}
+	s.index.Set(sink{session}, roomID)
+}
+
+// isAdmin reports whether token matches the server's configured admin
+// token. An empty AdminToken means admin bypass is disabled entirely.
+func (s *Server) isAdmin(token string) bool {
+	return s.cfg.AdminToken != "" && token == s.cfg.AdminToken
+}
+
+func (s *Server) startGame(session *melody.Session, in *protocol.StartGame) {
+	roomID, ok := s.index.Get(sink{session})
+	if !ok {
+		s.sendError(session, protocol.NotInRoom, "cannot start the game before joining a room", "")
+		return
+	}
+	rm, ok := s.rooms.Get(roomID)
+	if !ok {
+		return
+	}
+	rm.StartGame(sink{session}, s.isAdmin(in.AdminToken))
+}
+
+func (s *Server) kickPlayer(session *melody.Session, in *protocol.KickPlayer) {
+	roomID, ok := s.index.Get(sink{session})
+	if !ok {
+		s.sendError(session, protocol.NotInRoom, "cannot kick a player before joining a room", "")
+		return
+	}
+	rm, ok := s.rooms.Get(roomID)
+	if !ok {
+		return
+	}
+	rm.KickPlayer(sink{session}, s.isAdmin(in.AdminToken), in.TargetID, in.Reason)
+}
+
+func (s *Server) closeRoom(session *melody.Session, in *protocol.CloseRoom) {
+	roomID, ok := s.index.Get(sink{session})
+	if !ok {
+		s.sendError(session, protocol.NotInRoom, "cannot close a room before joining a room", "")
+		return
+	}
+	rm, ok := s.rooms.Get(roomID)
+	if !ok {
+		return
+	}
+	rm.CloseRoom(sink{session}, s.isAdmin(in.AdminToken))
+}
+
+// relayRtc forwards a WebRTC signaling message, unmodified, to the addressed
+// peer within the sender's room. The server never inspects the SDP or ICE
+// candidate payload, it only routes on player IDs.
+func (s *Server) relayRtc(session *melody.Session, sig *protocol.RtcSignal, msg []byte) {
+	roomID, ok := s.index.Get(sink{session})
+	if !ok {
+		s.sendError(session, protocol.NotInRoom, "cannot send a signaling message before joining a room", "")
+		return
+	}
+	rm, ok := s.rooms.Get(roomID)
+	if !ok {
+		return
+	}
+	rm.Relay(sink{session}, sig.ToPlayerID, msg)
+}
+
+// sendIceServers replies with the server's configured STUN/TURN servers.
+// Unlike the signaling commands, this doesn't require having joined a room.
+func (s *Server) sendIceServers(session *melody.Session) {
+	b, err := protocol.Marshal(protocol.IceServersResponse{
+		Command:    protocol.IceServersCommand,
+		IceServers: s.cfg.IceServers,
+	})
+	if err != nil {
+		fmt.Printf("wsserver: marshal ice servers response: %s \n", err.Error())
+		return
+	}
+	if err := (sink{session}).Send(b); err != nil {
+		fmt.Printf("wsserver: send ice servers response: %s \n", err.Error())
+	}
+}
+
+func (s *Server) broadcast(session *melody.Session, msg []byte) {
+	roomID, ok := s.index.Get(sink{session})
+	if !ok {
+		s.sendError(session, protocol.NotInRoom, "cannot send a message before joining a room", "")
+		return
+	}
+	rm, ok := s.rooms.Get(roomID)
+	if !ok {
+		return
+	}
+	rm.Broadcast(msg)
+}
+
+func (s *Server) broadcastOthers(session *melody.Session, msg []byte) {
+	roomID, ok := s.index.Get(sink{session})
+	if !ok {
+		s.sendError(session, protocol.NotInRoom, "cannot send MOVEMENT before joining a room", "")
+		return
+	}
+	rm, ok := s.rooms.Get(roomID)
+	if !ok {
+		return
+	}
+	rm.BroadcastOthers(sink{session}, msg)
+}
+
+// joinRandomRoom scans rooms in deterministic order for one that is both
+// unstarted and under capacity, preferring the first that hasn't yet
+// reached MinPlayers so players cluster together instead of spreading thin
+// across many half-empty rooms. If nothing is joinable, it opens a new one.
+func (s *Server) joinRandomRoom(session *melody.Session, playerID string) {
+	var fallback string
+	for _, id := range s.rooms.IDs() {
+		rm, ok := s.rooms.Get(id)
+		if !ok {
+			continue
+		}
+		stats := rm.Stats()
+		if stats.Started {
+			continue
+		}
+		if stats.Capacity > 0 && stats.PlayerCount >= stats.Capacity {
+			continue
+		}
+		if fallback == "" {
+			fallback = id
+		}
+		if s.cfg.MinPlayers == 0 || stats.PlayerCount < s.cfg.MinPlayers {
+			fmt.Printf("joining room %s \n", id)
+			s.joinRoom(session, playerID, id)
+			return
+		}
+	}
+
+	if fallback != "" {
+		fmt.Printf("joining room %s \n", fallback)
+		s.joinRoom(session, playerID, fallback)
+		return
+	}
+
+	// Fallback if there are no current joinable rooms
+	s.createRoom(session, &protocol.CreateRoom{PlayerID: playerID, Data: protocol.RoomData{RoomName: "shua"}})
+}
+
+func (s *Server) joinRoom(session *melody.Session, playerID, roomName string) {
+	rm, ok := s.rooms.Get(roomName)
+	if !ok {
+		s.sendError(session, protocol.RoomNotFound, fmt.Sprintf("room %q does not exist", roomName), "")
+		return
+	}
+
+	// A connection can only ever belong to one room. Rejoining the same room
+	// is the idempotent-rejoin case room.Join handles; trying to join a
+	// second, different room over this connection is a genuine conflict.
+	if existing, ok := s.index.Get(sink{session}); ok && existing != rm.ID {
+		s.sendError(session, protocol.AlreadyJoined, fmt.Sprintf("already joined room %q over this connection", existing), "")
+		return
+	}
+
+	if !rm.Join(room.Player{ID: playerID, Sink: sink{session}}) {
+		return
+	}
+	s.index.Set(sink{session}, rm.ID)
+	fmt.Printf("joined room %s", rm.ID)
+}
+
+// sendError replies to a single session with a typed ERROR message instead
+// of silently logging and dropping whatever they sent.
+func (s *Server) sendError(session *melody.Session, code protocol.ErrorCode, msg, details string) {
+	b, err := protocol.Marshal(protocol.Error{
+		Command: protocol.ErrorCommand,
+		Code:    code,
+		Message: msg,
+		Details: details,
+	})
+	if err != nil {
+		fmt.Printf("wsserver: marshal error response: %s \n", err.Error())
+		return
+	}
+	if err := (sink{session}).Send(b); err != nil {
+		fmt.Printf("wsserver: send error response: %s \n", err.Error())
+	}
+}
+
+func (s *Server) createRoom(session *melody.Session, msg *protocol.CreateRoom) {
+	if existing, ok := s.index.Get(sink{session}); ok {
+		s.sendError(session, protocol.AlreadyJoined, fmt.Sprintf("already joined room %q over this connection", existing), "")
+		return
+	}
+
+	if msg.Data.RoomName == "" {
+		s.sendError(session, protocol.BadRequest, "roomName must not be empty", "")
+		return
+	}
+	roomName := msg.Data.RoomName
+	if len(roomName) > 4 {
+		roomName = roomName[0:4]
+	}
+
+	rm := room.New(roomName, room.Player{ID: msg.PlayerID, Sink: sink{session}}, context.Background(), room.Config{
+		Timeout:     s.cfg.RoomTimeout,
+		HistorySize: s.cfg.HistorySize,
+		ResumeGrace: s.cfg.ResumeGrace,
+		Capacity:    s.cfg.PerRoom,
+		OnClose:     s.rooms.Remove,
+		Resume:      s.tokens,
+	})
+	s.rooms.Set(rm)
+	s.index.Set(sink{session}, roomName)
+	fmt.Printf("created room %s", roomName)
+}